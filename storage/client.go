@@ -16,6 +16,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	gax "github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/option"
@@ -24,6 +25,42 @@ import (
 
 // TODO(noahdietz): Move existing factory methods to this file.
 
+// Client is a client for interacting with Google Cloud Storage.
+//
+// Clients should be reused instead of created as needed. The methods of
+// Client are safe for concurrent use by multiple goroutines.
+//
+// Every network operation issued by Client, and by the BucketHandle,
+// ObjectHandle, ACLHandle, IAMHandle, Reader, Writer, Copier, and Composer
+// values it creates, is routed through tc. NewClient backs tc with an
+// httpStorageClient; NewGRPCClient backs it with a grpcStorageClient. Tests
+// can set tc directly to a fake storageClient instead of spinning up an
+// httptest server.
+type Client struct {
+	tc storageClient
+
+	// settings is the same *settings instance backing tc, shared rather than
+	// copied so that SetRetry is visible to calls tc makes after it runs.
+	settings *settings
+}
+
+// SetRetry configures the default retry behavior for operations performed by
+// this Client. This configuration is used for all operations issued by the
+// Client that do not have a more specific retry policy set, e.g. via
+// BucketHandle.Retryer or ObjectHandle.Retryer.
+//
+// This should be set before the Client is used for any operations.
+func (c *Client) SetRetry(opts ...RetryOption) {
+	c.settings.retry = newRetryConfig(opts...)
+}
+
+// StorageUnimplementedErr is returned by storageClient methods that are not
+// supported by a given transport implementation. Not all RPCs in the
+// interface are necessarily available on every transport (for example, HMAC
+// key management has no gRPC equivalent), so implementations should return
+// this sentinel rather than panicking or silently no-opping.
+var StorageUnimplementedErr = errors.New("storage: method is not implemented by this transport")
+
 // storageClient is an internal-only interface designed to separate the
 // transport-specific logic of making Storage API calls from the logic of the
 // client library.
@@ -35,8 +72,8 @@ import (
 // * all API errors must be wrapped in the gax-go APIError type
 // * any unimplemented interface methods must return a StorageUnimplementedErr
 //
-// TODO(noahdietz): This interface is currently not used in the production code
-// paths
+// Client.tc, and every handle created from a Client, route their network
+// operations through this interface; see NewClient and NewGRPCClient.
 type storageClient interface {
 
 	// Top-level methods.
@@ -0,0 +1,302 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// RewriteState is an opaque, JSON-marshalable checkpoint for a long-running
+// Copier.RunLongRunning call. Persist it (to disk, or to another GCS object)
+// so a copy of a multi-TB object can be resumed from a fresh process instead
+// of restarting from byte zero.
+type RewriteState struct {
+	// Token is the server-issued rewrite token to resume from. An empty
+	// Token means the copy has not started yet.
+	Token string `json:"token"`
+
+	// Written is the number of bytes copied so far, as of Token.
+	Written int64 `json:"written"`
+
+	// Done reports whether the copy has completed.
+	Done bool `json:"done"`
+}
+
+// Copier copies a source object to a destination object, optionally
+// modifying attributes along the way. Use ObjectHandle.CopierFrom to create
+// one.
+type Copier struct {
+	// ObjectAttrs are optional attributes to set on the destination object.
+	ObjectAttrs
+
+	// PredefinedACL, if not empty, applies a predefined ACL to the
+	// destination object.
+	PredefinedACL string
+
+	// ProgressFunc, if set, is invoked after each underlying RewriteObject
+	// RPC with the number of bytes written so far and the total size of the
+	// source object.
+	ProgressFunc func(written, size int64)
+
+	dst, src *ObjectHandle
+}
+
+// RunLongRunning repeatedly calls the underlying RewriteObject RPC until the
+// copy is done, checkpointing progress into state after every chunk and
+// invoking ProgressFunc, if set, with the bytes written so far and the total
+// size of the source object.
+//
+// Pass a non-nil state restored from a previous call (for example via
+// json.Unmarshal of a persisted RewriteState) to resume a copy that was
+// interrupted; RunLongRunning resumes from state.Token instead of starting
+// over. Pass nil to start a new copy.
+func (c *Copier) RunLongRunning(ctx context.Context, state *RewriteState) (*ObjectAttrs, error) {
+	if state == nil {
+		state = &RewriteState{}
+	}
+
+	var size int64
+	if c.ProgressFunc != nil {
+		attrs, err := c.src.c.tc.GetObject(ctx, c.src.bucket, c.src.object, c.src.conds)
+		if err != nil {
+			return nil, err
+		}
+		size = attrs.Size
+	}
+
+	req := c.newRewriteObjectRequest(state.Token)
+	for {
+		resp, err := c.dst.c.tc.RewriteObject(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		state.Token = resp.token
+		state.Written = resp.written
+		state.Done = resp.done
+		req.token = resp.token
+
+		if c.ProgressFunc != nil {
+			c.ProgressFunc(resp.written, size)
+		}
+		if resp.done {
+			return resp.resource, nil
+		}
+	}
+}
+
+func (c *Copier) newRewriteObjectRequest(token string) *rewriteObjectRequest {
+	return &rewriteObjectRequest{
+		srcBucket:     c.src.bucket,
+		srcObject:     c.src.object,
+		dstBucket:     c.dst.bucket,
+		dstObject:     c.dst.object,
+		attrs:         &c.ObjectAttrs,
+		conds:         c.dst.conds,
+		predefinedACL: c.PredefinedACL,
+		token:         token,
+	}
+}
+
+// maxComposeSources is the maximum number of source objects the
+// ComposeObject RPC accepts in a single call.
+const maxComposeSources = 32
+
+// ComposeState is an opaque, JSON-marshalable checkpoint for a long-running
+// Composer.RunLongRunning call, the compose-tree analog of RewriteState.
+// Persist it so a >maxComposeSources compose interrupted partway through its
+// tree can resume instead of restarting from the original sources (which
+// would also orphan any intermediate objects already created).
+type ComposeState struct {
+	// Total is the number of original source objects being composed.
+	Total int `json:"total"`
+
+	// Level holds the names of the objects (original sources, or
+	// intermediates from a finished tree level) not yet folded into an
+	// intermediate compose for the level currently in progress.
+	Level []string `json:"level"`
+
+	// NextLevel holds the names of the intermediate objects already
+	// composed for the level currently in progress, waiting for Level to be
+	// fully drained before they become the next Level. Keeping this
+	// separate from Level, and updating both after every single chunk
+	// rather than once per level, is what lets a resumed call skip chunks
+	// already composed instead of redoing (and re-orphaning) the whole
+	// level.
+	NextLevel []string `json:"nextLevel"`
+
+	// Composed is the number of original source objects folded into a
+	// completed intermediate or the final object so far.
+	Composed int `json:"composed"`
+
+	// Intermediates holds the names of every intermediate object created so
+	// far, so they can be cleaned up once the compose finishes, or by a
+	// separate pass if the process exits again before cleanup runs.
+	Intermediates []string `json:"intermediates"`
+
+	// Done reports whether the compose has completed.
+	Done bool `json:"done"`
+}
+
+// Composer composes srcs into a destination object. Use
+// ObjectHandle.ComposerFrom to create one.
+type Composer struct {
+	// ObjectAttrs holds attributes for the composed object. RunLongRunning
+	// does not include them in its compose requests; only the destination
+	// bucket and name are sent.
+	ObjectAttrs
+
+	// PredefinedACL, if not empty, applies a predefined ACL to the composed
+	// object (and to any intermediate objects created along the way).
+	PredefinedACL string
+
+	// ProgressFunc, if set, is invoked after each intermediate compose RPC
+	// with the number of source objects composed so far and the total
+	// number of sources.
+	ProgressFunc func(composed, total int)
+
+	dst  *ObjectHandle
+	srcs []*ObjectHandle
+}
+
+// RunLongRunning composes c.srcs into the destination object. When there are
+// more than maxComposeSources sources, it transparently splits the compose
+// into a tree of intermediate compositions so objects assembled from any
+// number of parts (for example, multi-TB objects copied across KMS keys or
+// regions) don't require the caller to write their own chunking loop.
+// Intermediate objects are created alongside the destination object and
+// removed once no longer needed.
+//
+// Pass a non-nil state restored from a previous call (for example via
+// json.Unmarshal of a persisted ComposeState) to resume a compose that was
+// interrupted partway through its tree; RunLongRunning resumes from
+// state.Level instead of starting over from c.srcs, and state.Intermediates
+// so a prior attempt's intermediate objects are still cleaned up. Pass nil
+// to start a new compose.
+func (c *Composer) RunLongRunning(ctx context.Context, state *ComposeState) (*ObjectAttrs, error) {
+	if state == nil {
+		state = &ComposeState{}
+	}
+	if state.Total == 0 && !state.Done {
+		state.Total = len(c.srcs)
+		state.Level = objectNames(c.srcs)
+	}
+
+	// Drain state.Level one chunk at a time, folding each finished chunk
+	// into state.NextLevel and shrinking state.Level as we go, rather than
+	// only replacing state.Level once the whole level is done. That way a
+	// resume after a mid-level failure picks up at the chunk that failed
+	// instead of recomposing (and orphaning) the chunks that already
+	// succeeded.
+	for len(state.Level) > maxComposeSources || len(state.NextLevel) > 0 {
+		if len(state.Level) == 0 {
+			state.Level = state.NextLevel
+			state.NextLevel = nil
+			continue
+		}
+
+		chunk, rest := firstComposeChunk(state.Level, maxComposeSources)
+		tmpName := intermediateComposeName(c.dst.object, len(state.Intermediates))
+		tmp := c.dst.c.Bucket(c.dst.bucket).Object(tmpName)
+		if _, err := c.compose(ctx, tmp, chunk); err != nil {
+			return nil, err
+		}
+
+		state.Level = rest
+		state.NextLevel = append(state.NextLevel, tmpName)
+		state.Intermediates = append(state.Intermediates, tmpName)
+		// Only first-level chunks hold original sources; once they are all
+		// folded in, Composed equals Total and later levels leave it as is.
+		if state.Composed < state.Total {
+			state.Composed += len(chunk)
+		}
+		if c.ProgressFunc != nil {
+			c.ProgressFunc(state.Composed, state.Total)
+		}
+	}
+
+	attrs, err := c.compose(ctx, c.dst, state.Level)
+	if err != nil {
+		return nil, err
+	}
+	state.Composed = state.Total
+	state.Done = true
+	if c.ProgressFunc != nil {
+		c.ProgressFunc(state.Composed, state.Total)
+	}
+	c.cleanup(ctx, state.Intermediates)
+	return attrs, nil
+}
+
+func (c *Composer) compose(ctx context.Context, dst *ObjectHandle, srcNames []string) (*ObjectAttrs, error) {
+	req := &composeObjectRequest{
+		dstBucket:     dst.bucket,
+		dstObject:     dst.object,
+		srcs:          srcNames,
+		conds:         dst.conds,
+		predefinedACL: c.PredefinedACL,
+	}
+	return dst.c.tc.ComposeObject(ctx, req)
+}
+
+// cleanup best-effort deletes the intermediate objects created while
+// splitting a large compose into a tree of smaller ones. Errors are ignored:
+// the destination object has already been composed successfully, and the
+// intermediates are named predictably enough for a later cleanup pass to
+// find them if this one fails.
+func (c *Composer) cleanup(ctx context.Context, intermediates []string) {
+	for _, name := range intermediates {
+		_ = c.dst.c.Bucket(c.dst.bucket).Object(name).Delete(ctx)
+	}
+}
+
+func intermediateComposeName(dst string, i int) string {
+	return fmt.Sprintf("%s.tmp-compose-%d", dst, i)
+}
+
+func objectNames(handles []*ObjectHandle) []string {
+	names := make([]string, len(handles))
+	for i, h := range handles {
+		names[i] = h.object
+	}
+	return names
+}
+
+// composeChunks splits names into ordered groups of at most size, the
+// tree-splitting math behind RunLongRunning's handling of >maxComposeSources
+// sources.
+func composeChunks(names []string, size int) [][]string {
+	var chunks [][]string
+	for i := 0; i < len(names); i += size {
+		end := i + size
+		if end > len(names) {
+			end = len(names)
+		}
+		chunks = append(chunks, names[i:end])
+	}
+	return chunks
+}
+
+// firstComposeChunk splits off the first chunk of at most size names,
+// returning it along with the remaining names. RunLongRunning uses this
+// instead of composeChunks so it can checkpoint state.Level after each
+// chunk rather than after the whole level.
+func firstComposeChunk(names []string, size int) (chunk, rest []string) {
+	if len(names) <= size {
+		return names, nil
+	}
+	return names[:size], names[size:]
+}
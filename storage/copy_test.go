@@ -0,0 +1,263 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+)
+
+func names(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("o%d", i)
+	}
+	return out
+}
+
+func TestComposeChunks(t *testing.T) {
+	cases := []struct {
+		name string
+		n    int
+		size int
+		want [][]string
+	}{
+		{"empty", 0, 32, nil},
+		{"fewer than one chunk", 5, 32, [][]string{names(5)}},
+		{"exactly one chunk", 32, 32, [][]string{names(32)}},
+		{"one more than a chunk", 33, 32, [][]string{names(32), {"o32"}}},
+		{"exactly two chunks", 64, 32, [][]string{names(32), names(64)[32:]}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := composeChunks(names(tc.n), tc.size)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("composeChunks(%d names, size %d) = %v, want %v", tc.n, tc.size, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestComposeChunksPreservesOrder(t *testing.T) {
+	got := composeChunks(names(70), 32)
+	if len(got) != 3 {
+		t.Fatalf("len(chunks) = %d, want 3", len(got))
+	}
+	if len(got[0]) != 32 || len(got[1]) != 32 || len(got[2]) != 6 {
+		t.Fatalf("chunk sizes = %d, %d, %d; want 32, 32, 6", len(got[0]), len(got[1]), len(got[2]))
+	}
+	if got[0][0] != "o0" || got[2][len(got[2])-1] != "o69" {
+		t.Errorf("chunks out of order: first=%q last=%q", got[0][0], got[2][len(got[2])-1])
+	}
+}
+
+// unimplementedStorageClient implements storageClient with every method
+// returning StorageUnimplementedErr, per the interface's documented
+// requirement. Embed it in a fake that only needs a handful of methods
+// instead of writing out the whole interface each time.
+type unimplementedStorageClient struct{}
+
+func (unimplementedStorageClient) GetServiceAccount(ctx context.Context, project string, opts ...storageOption) (string, error) {
+	return "", StorageUnimplementedErr
+}
+func (unimplementedStorageClient) CreateBucket(ctx context.Context, project string, attrs *BucketAttrs, opts ...storageOption) (*BucketAttrs, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ListBuckets(ctx context.Context, project string, opts ...storageOption) (*BucketIterator, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) DeleteBucket(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) GetBucket(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) (*BucketAttrs, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) UpdateBucket(ctx context.Context, uattrs *BucketAttrsToUpdate, conds *BucketConditions, opts ...storageOption) (*BucketAttrs, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) LockBucketRetentionPolicy(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ListObjects(ctx context.Context, bucket string, q *Query, opts ...storageOption) (*ObjectIterator, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) DeleteObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) GetObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) (*ObjectAttrs, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) UpdateObject(ctx context.Context, bucket, object string, uattrs *ObjectAttrsToUpdate, conds *Conditions, opts ...storageOption) (*ObjectAttrs, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) DeleteDefaultObjectACL(ctx context.Context, bucket string, entity ACLEntity, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ListDefaultObjectACLs(ctx context.Context, bucket string, opts ...storageOption) ([]ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) UpdateDefaultObjectACL(ctx context.Context, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) DeleteBucketACL(ctx context.Context, bucket string, entity ACLEntity, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ListBucketACLs(ctx context.Context, bucket string, opts ...storageOption) ([]ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) UpdateBucketACL(ctx context.Context, bucket string, entity ACLEntity, role ACLRole, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) DeleteObjectACL(ctx context.Context, bucket, object string, entity ACLEntity, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ListObjectACLs(ctx context.Context, bucket, object string, opts ...storageOption) ([]ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) UpdateObjectACL(ctx context.Context, bucket, object string, entity ACLEntity, role ACLRole, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ComposeObject(ctx context.Context, req *composeObjectRequest, opts ...storageOption) (*ObjectAttrs, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) RewriteObject(ctx context.Context, req *rewriteObjectRequest, opts ...storageOption) (*rewriteObjectResponse, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) OpenReader(ctx context.Context, r *Reader, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) OpenWriter(ctx context.Context, w *Writer, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) GetIamPolicy(ctx context.Context, resource string, version int32, opts ...storageOption) (*iampb.Policy, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) SetIamPolicy(ctx context.Context, resource string, policy *iampb.Policy, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+func (unimplementedStorageClient) TestIamPermissions(ctx context.Context, resource string, permissions []string, opts ...storageOption) ([]string, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) GetHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) (*HMACKey, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) ListHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) *HMACKeysIterator {
+	return nil
+}
+func (unimplementedStorageClient) UpdateHMACKey(ctx context.Context, desc *hmacKeyDesc, attrs *HMACKeyAttrsToUpdate, opts ...storageOption) (*HMACKey, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) CreateHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) (*HMACKey, error) {
+	return nil, StorageUnimplementedErr
+}
+func (unimplementedStorageClient) DeleteHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+
+// fakeComposeClient is a storageClient that only implements ComposeObject and
+// DeleteObject, recording every compose call and optionally failing the
+// call'th ComposeObject so tests can exercise RunLongRunning's resume path.
+type fakeComposeClient struct {
+	unimplementedStorageClient
+
+	failOnCall int // 1-indexed; 0 means never fail
+	calls      int
+	composed   []*composeObjectRequest
+	deleted    []string
+}
+
+func (f *fakeComposeClient) ComposeObject(ctx context.Context, req *composeObjectRequest, opts ...storageOption) (*ObjectAttrs, error) {
+	f.calls++
+	if f.failOnCall != 0 && f.calls == f.failOnCall {
+		return nil, errors.New("fakeComposeClient: simulated failure")
+	}
+	f.composed = append(f.composed, req)
+	return &ObjectAttrs{Bucket: req.dstBucket, Name: req.dstObject}, nil
+}
+
+func (f *fakeComposeClient) DeleteObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) error {
+	f.deleted = append(f.deleted, object)
+	return nil
+}
+
+func newTestComposer(fake storageClient, dstName string, n int) *Composer {
+	client := &Client{tc: fake}
+	dst := &ObjectHandle{c: client, bucket: "b", object: dstName}
+	srcs := make([]*ObjectHandle, n)
+	for i, name := range names(n) {
+		srcs[i] = &ObjectHandle{c: client, bucket: "b", object: name}
+	}
+	return &Composer{dst: dst, srcs: srcs}
+}
+
+// TestComposerRunLongRunningResumesMidLevel exercises the bug the checkpoint
+// granularity in ComposeState exists to prevent: failing partway through a
+// level's chunks must not force a resumed call to recompose (and orphan) the
+// chunks that already succeeded.
+func TestComposerRunLongRunningResumesMidLevel(t *testing.T) {
+	// 70 sources split into chunks of 32 need three intermediate composes
+	// (32, 32, 6) before the final compose into the destination. Fail the
+	// second intermediate compose so the first has already succeeded when
+	// RunLongRunning returns its error.
+	fake := &fakeComposeClient{failOnCall: 2}
+	c := newTestComposer(fake, "dst", 70)
+
+	state := &ComposeState{}
+	if _, err := c.RunLongRunning(context.Background(), state); err == nil {
+		t.Fatal("RunLongRunning: got nil error, want the simulated failure")
+	}
+	if fake.calls != 2 {
+		t.Fatalf("calls after failure = %d, want 2", fake.calls)
+	}
+	if len(state.Intermediates) != 1 {
+		t.Fatalf("state.Intermediates after failure = %v, want exactly the one completed chunk", state.Intermediates)
+	}
+	firstIntermediate := state.Intermediates[0]
+
+	// Resume with the same (now-failed) fake replaced by one that always
+	// succeeds, and confirm the already-completed chunk isn't redone.
+	resumed := &fakeComposeClient{}
+	c.dst.c.tc = resumed
+	for _, s := range c.srcs {
+		s.c.tc = resumed
+	}
+	attrs, err := c.RunLongRunning(context.Background(), state)
+	if err != nil {
+		t.Fatalf("RunLongRunning on resume: %v", err)
+	}
+	if attrs.Name != "dst" {
+		t.Errorf("attrs.Name = %q, want %q", attrs.Name, "dst")
+	}
+	if !state.Done || state.Composed != 70 {
+		t.Errorf("state after resume = %+v, want Done with Composed=70", state)
+	}
+	// Only the second (32-source) chunk, the third (6-source) chunk and the
+	// final compose should run after resuming: 3 RPCs, not the 4 a
+	// from-scratch redo of the level would take.
+	if resumed.calls != 3 {
+		t.Errorf("calls after resume = %d, want 3 (two remaining intermediates + final compose)", resumed.calls)
+	}
+	if len(state.Intermediates) != 3 {
+		t.Fatalf("state.Intermediates after resume = %v, want 3 total", state.Intermediates)
+	}
+	if state.Intermediates[0] != firstIntermediate {
+		t.Errorf("state.Intermediates[0] = %q, want the chunk completed before the failure (%q) to be reused, not redone", state.Intermediates[0], firstIntermediate)
+	}
+}
@@ -0,0 +1,489 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+
+	gapic "cloud.google.com/go/storage/internal/apiv2"
+	storagepb "cloud.google.com/go/storage/internal/apiv2/storagepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// NewGRPCClient creates a new Client that uses the gRPC Storage API rather
+// than the default JSON/HTTP transport used by NewClient. It is intended for
+// workloads that want lower latency and the streaming upload/download paths
+// that only gRPC exposes.
+//
+// This is an experimental API and subject to change.
+func NewGRPCClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	s := initSettings(withClientOptions(opts...))
+	tc, err := newGRPCStorageClient(ctx, "", s)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{tc: tc, settings: s}, nil
+}
+
+// userProjectMetadataKey is the gRPC metadata key the server reads the
+// billing project for requester-pays buckets from, equivalent to the
+// userProject query parameter on the JSON/HTTP transport.
+const userProjectMetadataKey = "x-goog-user-project"
+
+// grpcStorageClient is the gRPC API implementation of the transport-agnostic
+// storageClient interface. It is backed by the auto-generated client in
+// internal/apiv2, and translates between the proto message types used on the
+// wire and the existing public types (ObjectAttrs, BucketAttrs, ACLRule, ...).
+//
+// The Storage gRPC API does not expose every RPC the JSON/HTTP API does; in
+// particular it has no equivalent of the legacy default/bucket/object ACL
+// list endpoints or HMAC key management. Those methods return
+// StorageUnimplementedErr so callers fail fast instead of silently talking to
+// the wrong transport.
+type grpcStorageClient struct {
+	raw         *gapic.Client
+	settings    *settings
+	userProject string
+}
+
+// newGRPCStorageClient initializes a new storageClient that speaks the gRPC
+// Storage API. userProject is the project to bill for operations that
+// support requester-pays buckets; it is threaded through as the
+// x-goog-user-project metadata value on every call. s is retained as-is (not
+// copied) so that later changes to it, such as Client.SetRetry, are visible
+// to calls made through the returned client.
+func newGRPCStorageClient(ctx context.Context, userProject string, s *settings) (storageClient, error) {
+	raw, err := gapic.NewClient(ctx, s.clientOption...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcStorageClient{raw: raw, settings: s, userProject: userProject}, nil
+}
+
+// withUserProject attaches c.userProject to ctx as x-goog-user-project
+// metadata, the gRPC equivalent of the userProject query parameter on the
+// JSON/HTTP transport, so requester-pays buckets can be billed to it. It's a
+// no-op when userProject is empty.
+func (c *grpcStorageClient) withUserProject(ctx context.Context) context.Context {
+	if c.userProject == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, userProjectMetadataKey, c.userProject)
+}
+
+// Close closes the underlying gRPC connection pool.
+func (c *grpcStorageClient) Close() error {
+	return c.raw.Close()
+}
+
+// Top-level methods.
+
+func (c *grpcStorageClient) GetServiceAccount(ctx context.Context, project string, opts ...storageOption) (string, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.GetServiceAccountRequest{Project: toProjectResource(project)}
+	var resp *storagepb.ServiceAccount
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.GetServiceAccount(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return "", err
+	}
+	return resp.GetEmailAddress(), nil
+}
+
+func (c *grpcStorageClient) CreateBucket(ctx context.Context, project string, attrs *BucketAttrs, opts ...storageOption) (*BucketAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.CreateBucketRequest{
+		Parent:   toProjectResource(project),
+		Bucket:   attrs.toProtoBucket(),
+		BucketId: attrs.Name,
+	}
+	var resp *storagepb.Bucket
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.CreateBucket(ctx, req, s.gax...)
+		return err
+	}, s.retry, s.idempotent)
+	if err != nil {
+		return nil, err
+	}
+	return newBucketFromProto(resp), nil
+}
+
+func (c *grpcStorageClient) ListBuckets(ctx context.Context, project string, opts ...storageOption) (*BucketIterator, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.ListBucketsRequest{Parent: toProjectResource(project)}
+	it := &BucketIterator{ctx: ctx}
+	gitr := c.raw.ListBuckets(ctx, req, s.gax...)
+	it.nextFunc = func() error {
+		item, err := gitr.Next()
+		if err == iterator.Done {
+			return err
+		}
+		if err != nil {
+			return toAPIErr(err)
+		}
+		it.items = append(it.items, newBucketFromProto(item))
+		return nil
+	}
+	return it, nil
+}
+
+// Bucket methods.
+
+func (c *grpcStorageClient) DeleteBucket(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.DeleteBucketRequest{Name: toBucketResource(bucket)}
+	if err := applyBucketCondsProto("grpcStorageClient.DeleteBucket", conds, req); err != nil {
+		return err
+	}
+	return run(ctx, func() error {
+		return c.raw.DeleteBucket(ctx, req, s.gax...)
+	}, s.retry, idempotentBucketConds(conds))
+}
+
+func (c *grpcStorageClient) GetBucket(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) (*BucketAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.GetBucketRequest{Name: toBucketResource(bucket)}
+	if err := applyBucketCondsProto("grpcStorageClient.GetBucket", conds, req); err != nil {
+		return nil, err
+	}
+	var resp *storagepb.Bucket
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.GetBucket(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return newBucketFromProto(resp), nil
+}
+
+func (c *grpcStorageClient) UpdateBucket(ctx context.Context, uattrs *BucketAttrsToUpdate, conds *BucketConditions, opts ...storageOption) (*BucketAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := uattrs.toProtoBucketUpdateRequest()
+	if err := applyBucketCondsProto("grpcStorageClient.UpdateBucket", conds, req); err != nil {
+		return nil, err
+	}
+	var resp *storagepb.Bucket
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.UpdateBucket(ctx, req, s.gax...)
+		return err
+	}, s.retry, idempotentBucketConds(conds))
+	if err != nil {
+		return nil, err
+	}
+	return newBucketFromProto(resp), nil
+}
+
+func (c *grpcStorageClient) LockBucketRetentionPolicy(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.LockBucketRetentionPolicyRequest{Bucket: toBucketResource(bucket)}
+	if conds != nil {
+		req.IfMetagenerationMatch = conds.MetagenerationMatch
+	}
+	return run(ctx, func() error {
+		_, err := c.raw.LockBucketRetentionPolicy(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+}
+
+func (c *grpcStorageClient) ListObjects(ctx context.Context, bucket string, q *Query, opts ...storageOption) (*ObjectIterator, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := q.toProtoListObjectsRequest(bucket)
+	it := &ObjectIterator{ctx: ctx}
+	gitr := c.raw.ListObjects(ctx, req, s.gax...)
+	it.nextFunc = func() error {
+		item, err := gitr.Next()
+		if err == iterator.Done {
+			return err
+		}
+		if err != nil {
+			return toAPIErr(err)
+		}
+		it.items = append(it.items, newObjectFromProto(item))
+		return nil
+	}
+	return it, nil
+}
+
+// Object metadata methods.
+
+func (c *grpcStorageClient) DeleteObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.DeleteObjectRequest{Bucket: toBucketResource(bucket), Object: object}
+	if err := applyCondsProto("grpcStorageClient.DeleteObject", defaultGen, conds, req); err != nil {
+		return err
+	}
+	return run(ctx, func() error {
+		return c.raw.DeleteObject(ctx, req, s.gax...)
+	}, s.retry, idempotentConds(conds))
+}
+
+func (c *grpcStorageClient) GetObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) (*ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.GetObjectRequest{Bucket: toBucketResource(bucket), Object: object}
+	if err := applyCondsProto("grpcStorageClient.GetObject", defaultGen, conds, req); err != nil {
+		return nil, err
+	}
+	var resp *storagepb.Object
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.GetObject(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromProto(resp), nil
+}
+
+func (c *grpcStorageClient) UpdateObject(ctx context.Context, bucket, object string, uattrs *ObjectAttrsToUpdate, conds *Conditions, opts ...storageOption) (*ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := uattrs.toProtoUpdateObjectRequest(bucket, object)
+	if err := applyCondsProto("grpcStorageClient.UpdateObject", defaultGen, conds, req); err != nil {
+		return nil, err
+	}
+	var resp *storagepb.Object
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.UpdateObject(ctx, req, s.gax...)
+		return err
+	}, s.retry, idempotentConds(conds))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromProto(resp), nil
+}
+
+// Default Object ACL, Bucket ACL and Object ACL methods.
+//
+// The gRPC Storage API has no standalone ACL RPCs; ACLs are read and written
+// as part of the owning Bucket/Object resource. Until the library grows a
+// read-modify-write shim over GetBucket/UpdateBucket and GetObject/
+// UpdateObject, these report StorageUnimplementedErr rather than incorrect
+// results; there is no automatic fallback to the HTTP transport.
+
+func (c *grpcStorageClient) DeleteDefaultObjectACL(ctx context.Context, bucket string, entity ACLEntity, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) ListDefaultObjectACLs(ctx context.Context, bucket string, opts ...storageOption) ([]ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) UpdateDefaultObjectACL(ctx context.Context, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) DeleteBucketACL(ctx context.Context, bucket string, entity ACLEntity, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) ListBucketACLs(ctx context.Context, bucket string, opts ...storageOption) ([]ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) UpdateBucketACL(ctx context.Context, bucket string, entity ACLEntity, role ACLRole, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) DeleteObjectACL(ctx context.Context, bucket, object string, entity ACLEntity, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) ListObjectACLs(ctx context.Context, bucket, object string, opts ...storageOption) ([]ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) UpdateObjectACL(ctx context.Context, bucket, object string, entity ACLEntity, role ACLRole, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+// Media operations.
+
+func (c *grpcStorageClient) ComposeObject(ctx context.Context, req *composeObjectRequest, opts ...storageOption) (*ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	preq := req.toProtoComposeObjectRequest()
+	var resp *storagepb.Object
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.ComposeObject(ctx, preq, s.gax...)
+		return err
+	}, s.retry, idempotentConds(req.conds))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromProto(resp), nil
+}
+
+func (c *grpcStorageClient) RewriteObject(ctx context.Context, req *rewriteObjectRequest, opts ...storageOption) (*rewriteObjectResponse, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	preq := req.toProtoRewriteObjectRequest()
+	var resp *storagepb.RewriteResponse
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.RewriteObject(ctx, preq, s.gax...)
+		return err
+	}, s.retry, idempotentConds(req.conds))
+	if err != nil {
+		return nil, err
+	}
+	res := &rewriteObjectResponse{done: resp.GetDone(), written: resp.GetTotalBytesRewritten(), token: resp.GetRewriteToken()}
+	if res.done {
+		res.resource = newObjectFromProto(resp.GetResource())
+	}
+	return res, nil
+}
+
+func (c *grpcStorageClient) OpenReader(ctx context.Context, r *Reader, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &storagepb.ReadObjectRequest{
+		Bucket: toBucketResource(r.o.bucket),
+		Object: r.o.object,
+	}
+	if r.offset > 0 {
+		req.ReadOffset = r.offset
+	}
+	if r.length > 0 {
+		req.ReadLimit = r.length
+	}
+	if err := applyCondsProto("grpcStorageClient.OpenReader", defaultGen, r.o.conds, req); err != nil {
+		return err
+	}
+
+	var stream storagepb.Storage_ReadObjectClient
+	err := run(ctx, func() error {
+		var err error
+		stream, err = c.raw.ReadObject(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return err
+	}
+
+	// The first response message carries the object's metadata alongside its
+	// first chunk of data; read it eagerly so Reader.Attrs is populated as
+	// soon as OpenReader returns, matching the HTTP transport's behavior.
+	resp, err := stream.Recv()
+	if err != nil {
+		return toAPIErr(err)
+	}
+
+	r.Attrs = *newObjectFromProto(resp.GetMetadata())
+	r.reader = &gRPCReader{stream: stream, buf: resp.GetChecksummedData().GetContent()}
+	return nil
+}
+
+func (c *grpcStorageClient) OpenWriter(ctx context.Context, w *Writer, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	return c.openWriterGRPC(ctx, w, s)
+}
+
+// IAM methods.
+
+func (c *grpcStorageClient) GetIamPolicy(ctx context.Context, resource string, version int32, opts ...storageOption) (*iampb.Policy, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &iampb.GetIamPolicyRequest{
+		Resource: toBucketResource(resource),
+		Options:  &iampb.GetPolicyOptions{RequestedPolicyVersion: version},
+	}
+	var resp *iampb.Policy
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.GetIamPolicy(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	return resp, err
+}
+
+func (c *grpcStorageClient) SetIamPolicy(ctx context.Context, resource string, policy *iampb.Policy, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &iampb.SetIamPolicyRequest{Resource: toBucketResource(resource), Policy: policy}
+	return run(ctx, func() error {
+		_, err := c.raw.SetIamPolicy(ctx, req, s.gax...)
+		return err
+	}, s.retry, false)
+}
+
+func (c *grpcStorageClient) TestIamPermissions(ctx context.Context, resource string, permissions []string, opts ...storageOption) ([]string, error) {
+	s := callSettings(c.settings, opts...)
+	ctx = c.withUserProject(ctx)
+	req := &iampb.TestIamPermissionsRequest{Resource: toBucketResource(resource), Permissions: permissions}
+	var resp *iampb.TestIamPermissionsResponse
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.TestIamPermissions(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetPermissions(), nil
+}
+
+// HMAC Key methods.
+//
+// HMAC key management has no gRPC Storage API equivalent; it is only
+// available via the JSON control plane.
+
+func (c *grpcStorageClient) GetHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) (*HMACKey, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) ListHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) *HMACKeysIterator {
+	it := &HMACKeysIterator{ctx: ctx}
+	it.nextFunc = func() error { return StorageUnimplementedErr }
+	return it
+}
+
+func (c *grpcStorageClient) UpdateHMACKey(ctx context.Context, desc *hmacKeyDesc, attrs *HMACKeyAttrsToUpdate, opts ...storageOption) (*HMACKey, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) CreateHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) (*HMACKey, error) {
+	return nil, StorageUnimplementedErr
+}
+
+func (c *grpcStorageClient) DeleteHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) error {
+	return StorageUnimplementedErr
+}
+
+var _ storageClient = (*grpcStorageClient)(nil)
@@ -0,0 +1,315 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	storagepb "cloud.google.com/go/storage/internal/apiv2/storagepb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// defaultGen is the sentinel generation value meaning "the live generation",
+// i.e. conds did not pin a specific one.
+const defaultGen = int64(0)
+
+// toProjectResource returns the gRPC resource name for a project, e.g.
+// "projects/my-project".
+func toProjectResource(project string) string {
+	return "projects/" + project
+}
+
+// toBucketResource returns the gRPC resource name for a bucket, e.g.
+// "projects/_/buckets/my-bucket".
+func toBucketResource(bucket string) string {
+	return "projects/_/buckets/" + bucket
+}
+
+// bucketIDFromResource extracts the bucket name from a gRPC bucket resource
+// name, the inverse of toBucketResource.
+func bucketIDFromResource(name string) string {
+	return strings.TrimPrefix(name, "projects/_/buckets/")
+}
+
+// newBucketFromProto translates a gRPC Bucket message into the transport
+// agnostic BucketAttrs.
+func newBucketFromProto(b *storagepb.Bucket) *BucketAttrs {
+	if b == nil {
+		return nil
+	}
+	attrs := &BucketAttrs{
+		Name:           bucketIDFromResource(b.GetName()),
+		Location:       b.GetLocation(),
+		StorageClass:   b.GetStorageClass(),
+		Metageneration: b.GetMetageneration(),
+		Etag:           b.GetEtag(),
+		Labels:         b.GetLabels(),
+	}
+	if v := b.GetVersioning(); v != nil {
+		attrs.VersioningEnabled = v.GetEnabled()
+	}
+	return attrs
+}
+
+// toProtoBucket translates a BucketAttrs into the gRPC Bucket message used
+// when creating a bucket.
+func (b *BucketAttrs) toProtoBucket() *storagepb.Bucket {
+	if b == nil {
+		return &storagepb.Bucket{}
+	}
+	pb := &storagepb.Bucket{
+		Name:         b.Name,
+		Location:     b.Location,
+		StorageClass: b.StorageClass,
+		Labels:       b.Labels,
+	}
+	if b.VersioningEnabled {
+		pb.Versioning = &storagepb.Bucket_Versioning{Enabled: true}
+	}
+	return pb
+}
+
+// name reports the bucket this update applies to.
+func (ua *BucketAttrsToUpdate) name() string {
+	return ua.bucket
+}
+
+// toProtoBucketUpdateRequest translates a BucketAttrsToUpdate into the gRPC
+// UpdateBucketRequest, including a field mask listing only the fields that
+// were actually set.
+func (ua *BucketAttrsToUpdate) toProtoBucketUpdateRequest() *storagepb.UpdateBucketRequest {
+	pb := &storagepb.Bucket{Name: toBucketResource(ua.name())}
+	var paths []string
+	if ua.StorageClass != "" {
+		pb.StorageClass = ua.StorageClass
+		paths = append(paths, "storage_class")
+	}
+	if ua.VersioningEnabled != nil {
+		pb.Versioning = &storagepb.Bucket_Versioning{Enabled: *ua.VersioningEnabled}
+		paths = append(paths, "versioning")
+	}
+	return &storagepb.UpdateBucketRequest{
+		Bucket:     pb,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+	}
+}
+
+// toProtoListObjectsRequest translates a Query into the gRPC
+// ListObjectsRequest for the given bucket.
+func (q *Query) toProtoListObjectsRequest(bucket string) *storagepb.ListObjectsRequest {
+	req := &storagepb.ListObjectsRequest{Parent: toBucketResource(bucket)}
+	if q != nil {
+		req.Prefix = q.Prefix
+		req.Delimiter = q.Delimiter
+		req.Versions = q.Versions
+		req.MatchGlob = q.MatchGlob
+	}
+	return req
+}
+
+// newObjectFromProto translates a gRPC Object message into the transport
+// agnostic ObjectAttrs.
+func newObjectFromProto(o *storagepb.Object) *ObjectAttrs {
+	if o == nil {
+		return nil
+	}
+	return &ObjectAttrs{
+		Bucket:         bucketIDFromResource(o.GetBucket()),
+		Name:           o.GetName(),
+		ContentType:    o.GetContentType(),
+		Size:           o.GetSize(),
+		Generation:     o.GetGeneration(),
+		Metageneration: o.GetMetageneration(),
+		Etag:           o.GetEtag(),
+	}
+}
+
+// toProtoObject translates an ObjectAttrs into the gRPC Object message used
+// to describe the destination of a write, rewrite, or compose.
+func (o ObjectAttrs) toProtoObject(bucket string) *storagepb.Object {
+	return &storagepb.Object{
+		Bucket:      toBucketResource(bucket),
+		Name:        o.Name,
+		ContentType: o.ContentType,
+	}
+}
+
+// toProtoComposeObjectRequest translates a composeObjectRequest into the
+// gRPC ComposeObjectRequest.
+func (req *composeObjectRequest) toProtoComposeObjectRequest() *storagepb.ComposeObjectRequest {
+	srcs := make([]*storagepb.ComposeObjectRequest_SourceObject, len(req.srcs))
+	for i, name := range req.srcs {
+		srcs[i] = &storagepb.ComposeObjectRequest_SourceObject{Name: name}
+	}
+	pb := &storagepb.ComposeObjectRequest{
+		Destination:              &storagepb.Object{Bucket: toBucketResource(req.dstBucket), Name: req.dstObject},
+		SourceObjects:            srcs,
+		DestinationPredefinedAcl: req.predefinedACL,
+	}
+	if req.conds != nil {
+		if req.conds.GenerationMatch != 0 {
+			pb.IfGenerationMatch = proto.Int64(req.conds.GenerationMatch)
+		}
+		if req.conds.MetagenerationMatch != 0 {
+			pb.IfMetagenerationMatch = proto.Int64(req.conds.MetagenerationMatch)
+		}
+	}
+	return pb
+}
+
+// toProtoRewriteObjectRequest translates a rewriteObjectRequest into the
+// gRPC RewriteObjectRequest.
+func (req *rewriteObjectRequest) toProtoRewriteObjectRequest() *storagepb.RewriteObjectRequest {
+	pb := &storagepb.RewriteObjectRequest{
+		SourceBucket:      toBucketResource(req.srcBucket),
+		SourceObject:      req.srcObject,
+		DestinationBucket: toBucketResource(req.dstBucket),
+		DestinationName:   req.dstObject,
+		RewriteToken:      req.token,
+
+		DestinationPredefinedAcl: req.predefinedACL,
+	}
+	if req.attrs != nil {
+		pb.Destination = req.attrs.toProtoObject(req.dstBucket)
+	}
+	if req.conds != nil {
+		if req.conds.GenerationMatch != 0 {
+			pb.IfGenerationMatch = proto.Int64(req.conds.GenerationMatch)
+		}
+		if req.conds.MetagenerationMatch != 0 {
+			pb.IfMetagenerationMatch = proto.Int64(req.conds.MetagenerationMatch)
+		}
+	}
+	return pb
+}
+
+// toProtoUpdateObjectRequest translates an ObjectAttrsToUpdate into the gRPC
+// UpdateObjectRequest, including a field mask listing only the fields that
+// were actually set.
+func (ua *ObjectAttrsToUpdate) toProtoUpdateObjectRequest(bucket, object string) *storagepb.UpdateObjectRequest {
+	pb := &storagepb.Object{Bucket: toBucketResource(bucket), Name: object}
+	var paths []string
+	if ua.ContentType != nil {
+		pb.ContentType = *ua.ContentType
+		paths = append(paths, "content_type")
+	}
+	return &storagepb.UpdateObjectRequest{
+		Object:     pb,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+	}
+}
+
+// applyCondsProto sets the generation/metageneration preconditions from
+// conds onto req, which must be one of the proto request types that carries
+// them. gen, when not defaultGen, pins the request to a specific object
+// generation.
+func applyCondsProto(method string, gen int64, conds *Conditions, req interface{}) error {
+	switch r := req.(type) {
+	case *storagepb.DeleteObjectRequest:
+		if gen != defaultGen {
+			r.Generation = gen
+		}
+		return applyObjectCondsProto(conds,
+			func(v int64) { r.IfGenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfGenerationNotMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationNotMatch = proto.Int64(v) },
+		)
+	case *storagepb.GetObjectRequest:
+		if gen != defaultGen {
+			r.Generation = gen
+		}
+		return applyObjectCondsProto(conds,
+			func(v int64) { r.IfGenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfGenerationNotMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationNotMatch = proto.Int64(v) },
+		)
+	case *storagepb.ReadObjectRequest:
+		if gen != defaultGen {
+			r.Generation = gen
+		}
+		return applyObjectCondsProto(conds,
+			func(v int64) { r.IfGenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfGenerationNotMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationNotMatch = proto.Int64(v) },
+		)
+	case *storagepb.UpdateObjectRequest:
+		return applyObjectCondsProto(conds,
+			func(v int64) { r.IfGenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfGenerationNotMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationMatch = proto.Int64(v) },
+			func(v int64) { r.IfMetagenerationNotMatch = proto.Int64(v) },
+		)
+	default:
+		return fmt.Errorf("storage: %s: unsupported request type %T for conditions", method, req)
+	}
+}
+
+func applyObjectCondsProto(conds *Conditions, setGenMatch, setGenNotMatch, setMetaMatch, setMetaNotMatch func(int64)) error {
+	if conds == nil {
+		return nil
+	}
+	if conds.GenerationMatch != 0 {
+		setGenMatch(conds.GenerationMatch)
+	}
+	if conds.GenerationNotMatch != 0 {
+		setGenNotMatch(conds.GenerationNotMatch)
+	}
+	if conds.MetagenerationMatch != 0 {
+		setMetaMatch(conds.MetagenerationMatch)
+	}
+	if conds.MetagenerationNotMatch != 0 {
+		setMetaNotMatch(conds.MetagenerationNotMatch)
+	}
+	return nil
+}
+
+// applyBucketCondsProto sets the metageneration preconditions from conds
+// onto req, which must be one of the proto request types that carries them.
+func applyBucketCondsProto(method string, conds *BucketConditions, req interface{}) error {
+	if conds == nil {
+		return nil
+	}
+	switch r := req.(type) {
+	case *storagepb.DeleteBucketRequest:
+		if conds.MetagenerationMatch != 0 {
+			r.IfMetagenerationMatch = proto.Int64(conds.MetagenerationMatch)
+		}
+		if conds.MetagenerationNotMatch != 0 {
+			r.IfMetagenerationNotMatch = proto.Int64(conds.MetagenerationNotMatch)
+		}
+	case *storagepb.GetBucketRequest:
+		if conds.MetagenerationMatch != 0 {
+			r.IfMetagenerationMatch = proto.Int64(conds.MetagenerationMatch)
+		}
+		if conds.MetagenerationNotMatch != 0 {
+			r.IfMetagenerationNotMatch = proto.Int64(conds.MetagenerationNotMatch)
+		}
+	case *storagepb.UpdateBucketRequest:
+		if conds.MetagenerationMatch != 0 {
+			r.IfMetagenerationMatch = proto.Int64(conds.MetagenerationMatch)
+		}
+		if conds.MetagenerationNotMatch != 0 {
+			r.IfMetagenerationNotMatch = proto.Int64(conds.MetagenerationNotMatch)
+		}
+	default:
+		return fmt.Errorf("storage: %s: unsupported request type %T for conditions", method, req)
+	}
+	return nil
+}
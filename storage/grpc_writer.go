@@ -0,0 +1,338 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	storagepb "cloud.google.com/go/storage/internal/apiv2/storagepb"
+)
+
+// maxPerMessageWriteSize caps how many bytes of object data are carried in a
+// single BidiWriteObjectRequest, keeping individual gRPC messages well under
+// the server's max message size. It is a ceiling, not a target: send reads
+// whatever is available up to this many bytes rather than waiting to fill the
+// buffer, so a Flush is never left waiting on data the caller already wrote.
+const maxPerMessageWriteSize = 2 * 1024 * 1024
+
+// gRPCWriter drives an upload over the BidiWriteObject streaming RPC. Unlike
+// a one-shot resumable upload, it lets the caller request a durability
+// checkpoint (Flush) without ending the stream, and recovers from a broken
+// stream by asking the server for the last persisted offset instead of
+// restarting the upload from scratch.
+//
+// send, sendWithRecovery, recover, and doFlush are only ever called from the
+// single goroutine running send(w); that goroutine is the sole owner of
+// gw.stream and is the only place stream.Send/stream.Recv are called, since
+// concurrent Send (or Recv) calls on one gRPC client stream are unsafe.
+// flush, called from whatever goroutine invokes Writer.Flush, never touches
+// the stream directly: it hands a request to the owning goroutine over
+// flushc and waits for the result.
+type gRPCWriter struct {
+	c      *grpcStorageClient
+	ctx    context.Context
+	s      *settings
+	pr     *io.PipeReader
+	bucket string
+	upload string // resumable upload id backing the stream
+
+	flushc chan *flushRequest
+	donec  chan struct{} // closed when send returns, so flush doesn't block forever
+
+	mu        sync.Mutex
+	stream    storagepb.Storage_BidiWriteObjectClient
+	sent      int64 // bytes written into the stream so far
+	persisted int64 // bytes the server has acknowledged as durable
+}
+
+// flushRequest is how flush asks the owning goroutine in send to issue a
+// Flush/StateLookup request on its behalf.
+type flushRequest struct {
+	respc chan flushResult
+}
+
+type flushResult struct {
+	offset int64
+	err    error
+}
+
+func (c *grpcStorageClient) startResumableWrite(ctx context.Context, w *Writer, s *settings) (string, error) {
+	req := &storagepb.StartResumableWriteRequest{
+		WriteObjectSpec: &storagepb.WriteObjectSpec{
+			Resource: w.ObjectAttrs.toProtoObject(w.o.bucket),
+		},
+	}
+	var resp *storagepb.StartResumableWriteResponse
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.StartResumableWrite(ctx, req, s.gax...)
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return "", err
+	}
+	return resp.GetUploadId(), nil
+}
+
+func (c *grpcStorageClient) openWriterGRPC(ctx context.Context, w *Writer, s *settings) error {
+	uploadID, err := c.startResumableWrite(ctx, w, s)
+	if err != nil {
+		return err
+	}
+	gw := &gRPCWriter{c: c, ctx: ctx, s: s, pr: w.pr, bucket: w.o.bucket, upload: uploadID, flushc: make(chan *flushRequest), donec: make(chan struct{})}
+	if err := gw.openStream(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.gw = gw
+	w.mu.Unlock()
+	// send must run to completion before OpenWriter returns: Writer.Close
+	// needs the real finalize response (or failure), not just confirmation
+	// that the stream opened.
+	return gw.send(w)
+}
+
+func (gw *gRPCWriter) openStream() error {
+	stream, err := gw.c.raw.BidiWriteObject(gw.ctx, gw.s.gax...)
+	if err != nil {
+		return toAPIErr(err)
+	}
+	gw.mu.Lock()
+	gw.stream = stream
+	gw.mu.Unlock()
+	return nil
+}
+
+// pipeRead is the result of a single read off gw.pr, delivered over a channel
+// so send's owning goroutine can select between it and flush requests
+// instead of blocking inside io.Reader.Read.
+type pipeRead struct {
+	n   int
+	err error
+}
+
+// send reads data off the upload pipe and streams it to the server as a
+// sequence of BidiWriteObjectRequest messages, finalizing the object when
+// the pipe is closed. It blocks until the upload has finished or failed, and
+// reports the outcome both as its return value and, under w.mu, via w.err/
+// w.obj so Close/Attrs observe it safely.
+//
+// Each iteration reads whatever is available up to maxPerMessageWriteSize
+// (a plain Read, not io.ReadFull) and sends it immediately, rather than
+// blocking until a full buffer has accumulated; otherwise a partially filled
+// buffer would sit unsent while a concurrent Flush reported an offset that
+// didn't account for it. Between reads, this goroutine also services
+// flush requests arriving on gw.flushc, since it is the only goroutine
+// allowed to use gw.stream.
+func (gw *gRPCWriter) send(w *Writer) (err error) {
+	defer close(gw.donec)
+	defer func() {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+	}()
+
+	buf := make([]byte, maxPerMessageWriteSize)
+	first := true
+	for {
+		n, readErr := gw.readOrFlush(buf)
+		finish := errors.Is(readErr, io.EOF)
+		if readErr != nil && !finish {
+			return readErr
+		}
+
+		req := &storagepb.BidiWriteObjectRequest{
+			Data: &storagepb.BidiWriteObjectRequest_ChecksummedData{
+				ChecksummedData: &storagepb.ChecksummedData{Content: buf[:n]},
+			},
+			WriteOffset: gw.writeOffset(),
+			FinishWrite: finish,
+		}
+		if first {
+			req.FirstMessage = &storagepb.BidiWriteObjectRequest_UploadId{UploadId: gw.upload}
+			first = false
+		}
+
+		if n > 0 || finish {
+			if err := gw.sendWithRecovery(req); err != nil {
+				return err
+			}
+			gw.addSent(int64(n))
+			if w.ProgressFunc != nil {
+				w.ProgressFunc(gw.writeOffset())
+			}
+		}
+
+		if finish {
+			resp, err := gw.stream.Recv()
+			if err != nil {
+				return toAPIErr(err)
+			}
+			w.mu.Lock()
+			w.obj = newObjectFromProto(resp.GetResource())
+			w.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// readOrFlush reads the next chunk off gw.pr into buf, servicing any flush
+// requests that arrive on gw.flushc while waiting for data. The pipe read
+// runs in its own goroutine so this can select on both.
+func (gw *gRPCWriter) readOrFlush(buf []byte) (int, error) {
+	readc := make(chan pipeRead, 1)
+	go func() {
+		n, err := gw.pr.Read(buf)
+		readc <- pipeRead{n, err}
+	}()
+
+	for {
+		select {
+		case r := <-readc:
+			return r.n, r.err
+		case fr := <-gw.flushc:
+			offset, err := gw.doFlush()
+			fr.respc <- flushResult{offset, err}
+		}
+	}
+}
+
+func (gw *gRPCWriter) writeOffset() int64 {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.sent
+}
+
+func (gw *gRPCWriter) addSent(n int64) {
+	gw.mu.Lock()
+	gw.sent += n
+	gw.mu.Unlock()
+}
+
+// maxRecoveryAttempts bounds how many times sendWithRecovery will reopen the
+// stream and resend the same chunk before giving up.
+const maxRecoveryAttempts = 3
+
+// sendWithRecovery sends req, and on a broken stream queries the committed
+// offset for the upload, reopens the stream, rewrites req to start at the
+// reconciled offset with FirstMessage set again (a fresh stream must
+// re-associate with the resumable upload), and resends it. Without this, a
+// send failure would silently drop the bytes already read off the pipe for
+// req, truncating the uploaded object.
+func (gw *gRPCWriter) sendWithRecovery(req *storagepb.BidiWriteObjectRequest) error {
+	for attempt := 0; ; attempt++ {
+		gw.mu.Lock()
+		stream := gw.stream
+		gw.mu.Unlock()
+
+		err := stream.Send(req)
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxRecoveryAttempts {
+			return toAPIErr(err)
+		}
+		if err := gw.recover(); err != nil {
+			return err
+		}
+		req.WriteOffset = gw.writeOffset()
+		req.FirstMessage = &storagepb.BidiWriteObjectRequest_UploadId{UploadId: gw.upload}
+	}
+}
+
+// recover asks the server for the offset it has durably persisted for this
+// resumable upload, rewinds the local write offset to match, and opens a
+// fresh stream so unacknowledged bytes can be resent.
+func (gw *gRPCWriter) recover() error {
+	req := &storagepb.QueryWriteStatusRequest{UploadId: gw.upload}
+	var resp *storagepb.QueryWriteStatusResponse
+	err := run(gw.ctx, func() error {
+		var err error
+		resp, err = gw.c.raw.QueryWriteStatus(gw.ctx, req, gw.s.gax...)
+		return err
+	}, gw.s.retry, true)
+	if err != nil {
+		return err
+	}
+
+	gw.mu.Lock()
+	gw.persisted = resp.GetPersistedSize()
+	gw.sent = gw.persisted
+	gw.mu.Unlock()
+
+	return gw.openStream()
+}
+
+// doFlush sends a flush/state-lookup request for the bytes sent so far and
+// blocks until the server reports back the offset it has durably persisted.
+// It must only be called from the goroutine running send, since it uses
+// gw.stream directly.
+func (gw *gRPCWriter) doFlush() (int64, error) {
+	gw.mu.Lock()
+	stream := gw.stream
+	offset := gw.sent
+	gw.mu.Unlock()
+
+	req := &storagepb.BidiWriteObjectRequest{
+		WriteOffset: offset,
+		Flush:       true,
+		StateLookup: true,
+	}
+	if err := stream.Send(req); err != nil {
+		return 0, toAPIErr(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return 0, toAPIErr(err)
+	}
+
+	gw.mu.Lock()
+	gw.persisted = resp.GetPersistedSize()
+	gw.mu.Unlock()
+	return resp.GetPersistedSize(), nil
+}
+
+// flush asks the goroutine running send to flush any data sent so far and
+// blocks for its response. It deliberately never touches gw.stream itself:
+// doing so from this goroutine, concurrently with send's own stream.Send/
+// stream.Recv calls, would violate grpc-go's single-goroutine-per-stream
+// contract and could corrupt the wire framing.
+func (gw *gRPCWriter) flush() (int64, error) {
+	gw.mu.Lock()
+	started := gw.stream != nil
+	gw.mu.Unlock()
+	if !started {
+		return 0, errors.New("storage: upload has not started yet")
+	}
+
+	fr := &flushRequest{respc: make(chan flushResult, 1)}
+	select {
+	case gw.flushc <- fr:
+	case <-gw.donec:
+		return 0, errors.New("storage: upload has already finished")
+	case <-gw.ctx.Done():
+		return 0, gw.ctx.Err()
+	}
+
+	select {
+	case res := <-fr.respc:
+		return res.offset, res.err
+	case <-gw.ctx.Done():
+		return 0, gw.ctx.Err()
+	}
+}
@@ -0,0 +1,649 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+)
+
+// httpStorageClient is the JSON/HTTP API implementation of the
+// transport-agnostic storageClient interface. It is backed by the existing
+// raw.Service client and preserves the behavior of the library prior to the
+// storageClient refactor byte-for-byte; OpenReader/OpenWriter in particular
+// delegate to the same range-read and resumable-upload machinery Reader and
+// Writer have always used.
+type httpStorageClient struct {
+	raw         *raw.Service
+	settings    *settings
+	userProject string
+}
+
+// newHTTPStorageClient initializes a new storageClient that speaks the
+// JSON/HTTP Storage API. This is the default transport used by NewClient.
+// userProject is the project to bill for operations that support
+// requester-pays buckets; it is sent as the userProject query parameter on
+// bucket create/get/update/delete, object get/update/delete, compose,
+// rewrite, and media read/write calls. The service account, bucket list,
+// retention policy lock, ACL, IAM, and HMAC calls do not send it. s is
+// retained as-is (not copied) so
+// that later changes to it, such as Client.SetRetry, are visible to calls
+// made through the returned client.
+func newHTTPStorageClient(ctx context.Context, userProject string, s *settings) (storageClient, error) {
+	raw, err := raw.NewService(ctx, s.clientOption...)
+	if err != nil {
+		return nil, err
+	}
+	return &httpStorageClient{raw: raw, settings: s, userProject: userProject}, nil
+}
+
+// NewClient creates a new Client for interacting with Google Cloud Storage.
+// It uses the JSON/HTTP transport. Use NewGRPCClient instead to use gRPC.
+//
+// Clients should be reused instead of created as needed. The methods of
+// Client are safe for concurrent use by multiple goroutines.
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	s := initSettings(withClientOptions(opts...))
+	tc, err := newHTTPStorageClient(ctx, "", s)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{tc: tc, settings: s}, nil
+}
+
+// Top-level methods.
+
+func (c *httpStorageClient) GetServiceAccount(ctx context.Context, project string, opts ...storageOption) (string, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Projects.ServiceAccount.Get(project)
+	var resp *raw.ServiceAccount
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return "", err
+	}
+	return resp.EmailAddress, nil
+}
+
+func (c *httpStorageClient) CreateBucket(ctx context.Context, project string, attrs *BucketAttrs, opts ...storageOption) (*BucketAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.Insert(project, attrs.toRawBucket())
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	var resp *raw.Bucket
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, s.idempotent)
+	if err != nil {
+		return nil, err
+	}
+	return newBucketFromRaw(resp), nil
+}
+
+func (c *httpStorageClient) ListBuckets(ctx context.Context, project string, opts ...storageOption) (*BucketIterator, error) {
+	s := callSettings(c.settings, opts...)
+	it := &BucketIterator{ctx: ctx}
+	var pageToken string
+	it.nextFunc = func() error {
+		call := c.raw.Buckets.List(project).PageToken(pageToken).Context(ctx)
+		var resp *raw.Buckets
+		if err := run(ctx, func() error {
+			var err error
+			resp, err = call.Do()
+			return err
+		}, s.retry, true); err != nil {
+			return err
+		}
+		for _, b := range resp.Items {
+			it.items = append(it.items, newBucketFromRaw(b))
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			return iterator.Done
+		}
+		return nil
+	}
+	return it, nil
+}
+
+// Bucket methods.
+
+func (c *httpStorageClient) DeleteBucket(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.Delete(bucket)
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyBucketConds("httpStorageClient.DeleteBucket", conds, call); err != nil {
+		return err
+	}
+	return run(ctx, func() error { return call.Context(ctx).Do() }, s.retry, idempotentBucketConds(conds))
+}
+
+func (c *httpStorageClient) GetBucket(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) (*BucketAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.Get(bucket).Projection("full")
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyBucketConds("httpStorageClient.GetBucket", conds, call); err != nil {
+		return nil, err
+	}
+	var resp *raw.Bucket
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return newBucketFromRaw(resp), nil
+}
+
+func (c *httpStorageClient) UpdateBucket(ctx context.Context, uattrs *BucketAttrsToUpdate, conds *BucketConditions, opts ...storageOption) (*BucketAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.Patch(uattrs.name(), uattrs.toRawBucket())
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyBucketConds("httpStorageClient.UpdateBucket", conds, call); err != nil {
+		return nil, err
+	}
+	var resp *raw.Bucket
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, idempotentBucketConds(conds))
+	if err != nil {
+		return nil, err
+	}
+	return newBucketFromRaw(resp), nil
+}
+
+func (c *httpStorageClient) LockBucketRetentionPolicy(ctx context.Context, bucket string, conds *BucketConditions, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	var metageneration int64
+	if conds != nil {
+		metageneration = conds.MetagenerationMatch
+	}
+	call := c.raw.Buckets.LockRetentionPolicy(bucket, metageneration)
+	return run(ctx, func() error { _, err := call.Context(ctx).Do(); return err }, s.retry, true)
+}
+
+func (c *httpStorageClient) ListObjects(ctx context.Context, bucket string, q *Query, opts ...storageOption) (*ObjectIterator, error) {
+	s := callSettings(c.settings, opts...)
+	it := &ObjectIterator{ctx: ctx}
+	var pageToken string
+	it.nextFunc = func() error {
+		call := q.toRawListObjectsCall(c.raw, bucket).PageToken(pageToken).Context(ctx)
+		var resp *raw.Objects
+		if err := run(ctx, func() error {
+			var err error
+			resp, err = call.Do()
+			return err
+		}, s.retry, true); err != nil {
+			return err
+		}
+		for _, o := range resp.Items {
+			it.items = append(it.items, newObjectFromRaw(o))
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			return iterator.Done
+		}
+		return nil
+	}
+	return it, nil
+}
+
+// Object metadata methods.
+
+func (c *httpStorageClient) DeleteObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Objects.Delete(bucket, object)
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyConds("httpStorageClient.DeleteObject", defaultGen, conds, call); err != nil {
+		return err
+	}
+	return run(ctx, func() error { return call.Context(ctx).Do() }, s.retry, idempotentConds(conds))
+}
+
+func (c *httpStorageClient) GetObject(ctx context.Context, bucket, object string, conds *Conditions, opts ...storageOption) (*ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Objects.Get(bucket, object).Projection("full")
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyConds("httpStorageClient.GetObject", defaultGen, conds, call); err != nil {
+		return nil, err
+	}
+	var resp *raw.Object
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromRaw(resp), nil
+}
+
+func (c *httpStorageClient) UpdateObject(ctx context.Context, bucket, object string, uattrs *ObjectAttrsToUpdate, conds *Conditions, opts ...storageOption) (*ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Objects.Patch(bucket, object, uattrs.toRawObject())
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyConds("httpStorageClient.UpdateObject", defaultGen, conds, call); err != nil {
+		return nil, err
+	}
+	var resp *raw.Object
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, idempotentConds(conds))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromRaw(resp), nil
+}
+
+// Default Object ACL methods.
+
+func (c *httpStorageClient) DeleteDefaultObjectACL(ctx context.Context, bucket string, entity ACLEntity, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.DefaultObjectAccessControls.Delete(bucket, string(entity))
+	return run(ctx, func() error { return call.Context(ctx).Do() }, s.retry, true)
+}
+
+func (c *httpStorageClient) ListDefaultObjectACLs(ctx context.Context, bucket string, opts ...storageOption) ([]ACLRule, error) {
+	s := callSettings(c.settings, opts...)
+	var resp *raw.ObjectAccessControls
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.DefaultObjectAccessControls.List(bucket).Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return toACLRules(resp.Items), nil
+}
+
+func (c *httpStorageClient) UpdateDefaultObjectACL(ctx context.Context, opts ...storageOption) (*ACLRule, error) {
+	return nil, StorageUnimplementedErr
+}
+
+// Bucket ACL methods.
+
+func (c *httpStorageClient) DeleteBucketACL(ctx context.Context, bucket string, entity ACLEntity, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.BucketAccessControls.Delete(bucket, string(entity))
+	return run(ctx, func() error { return call.Context(ctx).Do() }, s.retry, true)
+}
+
+func (c *httpStorageClient) ListBucketACLs(ctx context.Context, bucket string, opts ...storageOption) ([]ACLRule, error) {
+	s := callSettings(c.settings, opts...)
+	var resp *raw.BucketAccessControls
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.BucketAccessControls.List(bucket).Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return toACLRules(resp.Items), nil
+}
+
+func (c *httpStorageClient) UpdateBucketACL(ctx context.Context, bucket string, entity ACLEntity, role ACLRole, opts ...storageOption) (*ACLRule, error) {
+	s := callSettings(c.settings, opts...)
+	acl := &raw.BucketAccessControl{Entity: string(entity), Role: string(role)}
+	var resp *raw.BucketAccessControl
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.BucketAccessControls.Update(bucket, string(entity), acl).Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return toACLRule(resp), nil
+}
+
+// Object ACL methods.
+
+func (c *httpStorageClient) DeleteObjectACL(ctx context.Context, bucket, object string, entity ACLEntity, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.ObjectAccessControls.Delete(bucket, object, string(entity))
+	return run(ctx, func() error { return call.Context(ctx).Do() }, s.retry, true)
+}
+
+func (c *httpStorageClient) ListObjectACLs(ctx context.Context, bucket, object string, opts ...storageOption) ([]ACLRule, error) {
+	s := callSettings(c.settings, opts...)
+	var resp *raw.ObjectAccessControls
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.ObjectAccessControls.List(bucket, object).Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return toACLRules(resp.Items), nil
+}
+
+func (c *httpStorageClient) UpdateObjectACL(ctx context.Context, bucket, object string, entity ACLEntity, role ACLRole, opts ...storageOption) (*ACLRule, error) {
+	s := callSettings(c.settings, opts...)
+	acl := &raw.ObjectAccessControl{Entity: string(entity), Role: string(role)}
+	var resp *raw.ObjectAccessControl
+	err := run(ctx, func() error {
+		var err error
+		resp, err = c.raw.ObjectAccessControls.Update(bucket, object, string(entity), acl).Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return toACLRule(resp), nil
+}
+
+// Media operations.
+
+func (c *httpStorageClient) ComposeObject(ctx context.Context, req *composeObjectRequest, opts ...storageOption) (*ObjectAttrs, error) {
+	s := callSettings(c.settings, opts...)
+	rawReq := req.toRawComposeRequest()
+	call := c.raw.Objects.Compose(req.dstBucket, req.dstObject, rawReq)
+	if req.predefinedACL != "" {
+		call = call.DestinationPredefinedAcl(req.predefinedACL)
+	}
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	var resp *raw.Object
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, idempotentConds(req.conds))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromRaw(resp), nil
+}
+
+func (c *httpStorageClient) RewriteObject(ctx context.Context, req *rewriteObjectRequest, opts ...storageOption) (*rewriteObjectResponse, error) {
+	s := callSettings(c.settings, opts...)
+	rawObj := req.attrs.toRawObject()
+	call := c.raw.Objects.Rewrite(req.srcBucket, req.srcObject, req.dstBucket, req.dstObject, rawObj)
+	if req.token != "" {
+		call = call.RewriteToken(req.token)
+	}
+	if req.predefinedACL != "" {
+		call = call.DestinationPredefinedAcl(req.predefinedACL)
+	}
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	var resp *raw.RewriteResponse
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, idempotentConds(req.conds))
+	if err != nil {
+		return nil, err
+	}
+	res := &rewriteObjectResponse{done: resp.Done, written: resp.TotalBytesRewritten, token: resp.RewriteToken}
+	if res.done {
+		res.resource = newObjectFromRaw(resp.Resource)
+	}
+	return res, nil
+}
+
+// OpenReader issues a range-read GET (alt=media) against the object and
+// hands the response body to r as its reader, the same media path Reader has
+// always used.
+func (c *httpStorageClient) OpenReader(ctx context.Context, r *Reader, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Objects.Get(r.o.bucket, r.o.object)
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyConds("httpStorageClient.OpenReader", defaultGen, r.o.conds, call); err != nil {
+		return err
+	}
+	if r.offset > 0 || r.length != 0 {
+		end := int64(-1)
+		if r.length > 0 {
+			end = r.offset + r.length - 1
+		}
+		call.Header().Set("Range", httpRangeHeader(r.offset, end))
+	}
+
+	var resp *http.Response
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Download()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return err
+	}
+
+	r.Attrs = attrsFromReaderResponse(r.o.bucket, r.o.object, resp)
+	r.reader = resp.Body
+	return nil
+}
+
+// httpRangeHeader formats offset/end (end == -1 meaning "to EOF") as an RFC
+// 7233 Range header value.
+func httpRangeHeader(offset, end int64) string {
+	if end < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, end)
+}
+
+// attrsFromReaderResponse builds the subset of ObjectAttrs a media download
+// response carries as headers, since alt=media responses return object
+// metadata as headers rather than as a JSON body.
+func attrsFromReaderResponse(bucket, object string, res *http.Response) ObjectAttrs {
+	attrs := ObjectAttrs{
+		Bucket:      bucket,
+		Name:        object,
+		ContentType: res.Header.Get("Content-Type"),
+		Size:        res.ContentLength,
+	}
+	if gen, err := strconv.ParseInt(res.Header.Get("X-Goog-Generation"), 10, 64); err == nil {
+		attrs.Generation = gen
+	}
+	if mgen, err := strconv.ParseInt(res.Header.Get("X-Goog-Metageneration"), 10, 64); err == nil {
+		attrs.Metageneration = mgen
+	}
+	if size, err := strconv.ParseInt(res.Header.Get("X-Goog-Stored-Content-Length"), 10, 64); err == nil {
+		attrs.Size = size
+	}
+	return attrs
+}
+
+// OpenWriter streams w's pipe into Objects.Insert, the same media path Writer
+// has always used: a resumable upload in w.ChunkSize chunks when ChunkSize is
+// non-zero, and a single request when it is zero. The resumable
+// protocol retries individual chunks internally; once the pipe has begun
+// draining it can't be replayed, so this call is not wrapped in run().
+func (c *httpStorageClient) OpenWriter(ctx context.Context, w *Writer, opts ...storageOption) error {
+	call := c.raw.Objects.Insert(w.o.bucket, w.ObjectAttrs.toRawObject()).
+		Media(w.pr, googleapi.ChunkSize(w.ChunkSize)).
+		Context(ctx)
+	if w.ProgressFunc != nil {
+		call = call.ProgressUpdater(func(current, total int64) { w.ProgressFunc(current) })
+	}
+	if c.userProject != "" {
+		call = call.UserProject(c.userProject)
+	}
+	if err := applyConds("httpStorageClient.OpenWriter", defaultGen, w.o.conds, call); err != nil {
+		return err
+	}
+
+	obj, err := call.Do()
+	if err != nil {
+		return toAPIErr(err)
+	}
+	w.mu.Lock()
+	w.obj = newObjectFromRaw(obj)
+	w.mu.Unlock()
+	return nil
+}
+
+// IAM methods.
+
+func (c *httpStorageClient) GetIamPolicy(ctx context.Context, resource string, version int32, opts ...storageOption) (*iampb.Policy, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.GetIamPolicy(resource).OptionsRequestedPolicyVersion(int64(version))
+	var resp *raw.Policy
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return rawPolicyToProto(resp), nil
+}
+
+func (c *httpStorageClient) SetIamPolicy(ctx context.Context, resource string, policy *iampb.Policy, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.SetIamPolicy(resource, protoPolicyToRaw(policy))
+	return run(ctx, func() error { _, err := call.Context(ctx).Do(); return err }, s.retry, false)
+}
+
+func (c *httpStorageClient) TestIamPermissions(ctx context.Context, resource string, permissions []string, opts ...storageOption) ([]string, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Buckets.TestIamPermissions(resource, permissions)
+	var resp *raw.TestIamPermissionsResponse
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Permissions, nil
+}
+
+// HMAC Key methods.
+
+func (c *httpStorageClient) GetHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) (*HMACKey, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Projects.HmacKeys.Get(desc.projectID, desc.accessID)
+	var resp *raw.HmacKeyMetadata
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	return newHMACKeyFromRaw(&raw.HmacKey{Metadata: resp}), nil
+}
+
+func (c *httpStorageClient) ListHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) *HMACKeysIterator {
+	s := callSettings(c.settings, opts...)
+	it := &HMACKeysIterator{ctx: ctx}
+	var pageToken string
+	it.nextFunc = func() error {
+		call := c.raw.Projects.HmacKeys.List(desc.projectID).PageToken(pageToken).Context(ctx)
+		var resp *raw.HmacKeysMetadata
+		if err := run(ctx, func() error {
+			var err error
+			resp, err = call.Do()
+			return err
+		}, s.retry, true); err != nil {
+			return err
+		}
+		for _, m := range resp.Items {
+			it.items = append(it.items, newHMACKeyFromRaw(&raw.HmacKey{Metadata: m}))
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			return iterator.Done
+		}
+		return nil
+	}
+	return it
+}
+
+func (c *httpStorageClient) UpdateHMACKey(ctx context.Context, desc *hmacKeyDesc, attrs *HMACKeyAttrsToUpdate, opts ...storageOption) (*HMACKey, error) {
+	s := callSettings(c.settings, opts...)
+	meta := &raw.HmacKeyMetadata{State: attrs.State}
+	call := c.raw.Projects.HmacKeys.Update(desc.projectID, desc.accessID, meta)
+	var resp *raw.HmacKeyMetadata
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, false)
+	if err != nil {
+		return nil, err
+	}
+	return newHMACKeyFromRaw(&raw.HmacKey{Metadata: resp}), nil
+}
+
+func (c *httpStorageClient) CreateHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) (*HMACKey, error) {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Projects.HmacKeys.Create(desc.projectID, desc.serviceAccountEmail)
+	var resp *raw.HmacKey
+	err := run(ctx, func() error {
+		var err error
+		resp, err = call.Context(ctx).Do()
+		return err
+	}, s.retry, false)
+	if err != nil {
+		return nil, err
+	}
+	return newHMACKeyFromRaw(resp), nil
+}
+
+func (c *httpStorageClient) DeleteHMACKey(ctx context.Context, desc *hmacKeyDesc, opts ...storageOption) error {
+	s := callSettings(c.settings, opts...)
+	call := c.raw.Projects.HmacKeys.Delete(desc.projectID, desc.accessID)
+	return run(ctx, func() error { return call.Context(ctx).Do() }, s.retry, true)
+}
+
+var _ storageClient = (*httpStorageClient)(nil)
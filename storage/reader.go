@@ -0,0 +1,74 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io"
+
+	storagepb "cloud.google.com/go/storage/internal/apiv2/storagepb"
+)
+
+// A Reader reads the contents of an object out of Cloud Storage. It
+// implements io.Reader and io.Closer; callers must call Close when finished
+// reading.
+type Reader struct {
+	// Attrs are a subset of the attributes for the object being read. They
+	// are populated once OpenReader returns successfully.
+	Attrs ObjectAttrs
+
+	reader io.ReadCloser
+
+	o      *ObjectHandle
+	offset int64
+	length int64
+}
+
+// Read reads up to len(p) bytes from the object. It implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// Close closes the Reader. It must be called once reading is done.
+func (r *Reader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+// gRPCReader adapts a Storage_ReadObjectClient stream to an io.ReadCloser,
+// serving the bytes carried by the first response message (already received
+// by OpenReader to populate Attrs) before pulling further chunks via Recv.
+type gRPCReader struct {
+	stream storagepb.Storage_ReadObjectClient
+	buf    []byte
+}
+
+func (r *gRPCReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		resp, err := r.stream.Recv()
+		if err != nil {
+			return 0, toAPIErr(err)
+		}
+		r.buf = resp.GetChecksummedData().GetContent()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *gRPCReader) Close() error {
+	return nil
+}
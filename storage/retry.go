@@ -0,0 +1,260 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy governs under what conditions the client will retry a failed
+// API call.
+type RetryPolicy int
+
+const (
+	// RetryIdempotent causes a call to be retried if it is idempotent (as
+	// determined by the presence of generation/metageneration preconditions)
+	// and the error is retryable. This is the default policy.
+	RetryIdempotent RetryPolicy = iota
+
+	// RetryAlways causes a call to be retried whenever the error is
+	// retryable, regardless of whether the call is idempotent.
+	RetryAlways
+
+	// RetryNever disables retries entirely.
+	RetryNever
+)
+
+// retryConfig describes how a storage operation should be retried if it
+// fails, and is threaded through every storageClient method via settings.
+type retryConfig struct {
+	backoff     *gax.Backoff
+	policy      RetryPolicy
+	shouldRetry func(err error) bool
+	maxAttempts *int
+}
+
+func (r *retryConfig) clone() *retryConfig {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	if r.backoff != nil {
+		b := *r.backoff
+		c.backoff = &b
+	}
+	return &c
+}
+
+// RetryOption configures the retry behavior for an API call attached at
+// Client, BucketHandle, or ObjectHandle scope. Options attached at a narrower
+// scope override those set on an enclosing one. Reader and Writer have no
+// retry options of their own; they use those of the Client they came from.
+type RetryOption interface {
+	apply(rc *retryConfig)
+}
+
+type withBackoff struct {
+	backoff gax.Backoff
+}
+
+func (wb withBackoff) apply(rc *retryConfig) { rc.backoff = &wb.backoff }
+
+// WithBackoff configures the backoff timing used for retries, such as the
+// initial delay, maximum delay, and growth factor.
+func WithBackoff(backoff gax.Backoff) RetryOption {
+	return &withBackoff{backoff: backoff}
+}
+
+type withPolicy struct {
+	policy RetryPolicy
+}
+
+func (wp withPolicy) apply(rc *retryConfig) { rc.policy = wp.policy }
+
+// WithPolicy sets which calls are retried. The default, RetryIdempotent,
+// retries only calls that are idempotent and fail with a retryable error.
+func WithPolicy(policy RetryPolicy) RetryOption {
+	return &withPolicy{policy: policy}
+}
+
+type withErrorFunc struct {
+	shouldRetry func(err error) bool
+}
+
+func (wef withErrorFunc) apply(rc *retryConfig) { rc.shouldRetry = wef.shouldRetry }
+
+// WithErrorFunc overrides the default function (ShouldRetry) used to decide
+// whether a returned error is retryable.
+func WithErrorFunc(shouldRetry func(err error) bool) RetryOption {
+	return &withErrorFunc{shouldRetry: shouldRetry}
+}
+
+type withMaxAttempts struct {
+	maxAttempts int
+}
+
+func (wma withMaxAttempts) apply(rc *retryConfig) { rc.maxAttempts = &wma.maxAttempts }
+
+// WithMaxAttempts caps the number of times an API call is attempted,
+// including the initial try, in the face of retryable errors. The default is
+// to retry until the context is done.
+func WithMaxAttempts(maxAttempts int) RetryOption {
+	return &withMaxAttempts{maxAttempts: maxAttempts}
+}
+
+func newRetryConfig(opts ...RetryOption) *retryConfig {
+	if len(opts) == 0 {
+		return nil
+	}
+	rc := &retryConfig{shouldRetry: ShouldRetry}
+	for _, opt := range opts {
+		opt.apply(rc)
+	}
+	return rc
+}
+
+// ShouldRetry reports whether err is retryable, per
+// https://cloud.google.com/storage/docs/retry-strategy. It is the default
+// classifier used when no WithErrorFunc option is supplied.
+func ShouldRetry(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		return ShouldRetry(apiErr.Unwrap())
+	}
+
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		return gErr.Code == http.StatusRequestTimeout ||
+			gErr.Code == http.StatusTooManyRequests ||
+			(gErr.Code >= http.StatusInternalServerError && gErr.Code < 600)
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return ShouldRetry(urlErr.Unwrap())
+	}
+
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Aborted, codes.Canceled, codes.Internal, codes.DeadlineExceeded,
+			codes.ResourceExhausted, codes.Unavailable:
+			return true
+		}
+	}
+
+	return false
+}
+
+// idempotentConds reports whether conds pins the call to a specific object
+// generation or metageneration, which is what makes an otherwise unsafe
+// write (delete, update) safe to retry blindly: a retried call either
+// reapplies to the same generation or fails its precondition instead of
+// silently being applied twice.
+func idempotentConds(conds *Conditions) bool {
+	return conds != nil && (conds.GenerationMatch != 0 || conds.MetagenerationMatch != 0)
+}
+
+// idempotentBucketConds is the bucket-scoped analog of idempotentConds.
+func idempotentBucketConds(conds *BucketConditions) bool {
+	return conds != nil && conds.MetagenerationMatch != 0
+}
+
+// toAPIErr wraps a non-nil error returned by the underlying JSON/HTTP or gRPC
+// client libraries in a gax-go APIError, so storageClient callers see one
+// consistent error type regardless of transport, per the storageClient
+// interface contract. Errors apierror.FromError doesn't recognize (neither a
+// googleapi.Error nor a gRPC status error) are returned unchanged rather than
+// discarded.
+func toAPIErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if apiErr, ok := apierror.FromError(err); ok {
+		return apiErr
+	}
+	return err
+}
+
+// run calls the given function, retrying it as directed by retry and
+// idempotent (whether the call is safe to repeat) using the policy and
+// backoff configured on retry. A nil retry uses the default gax.Backoff and
+// retries idempotent calls until ctx is done. The error it ultimately
+// returns is wrapped via toAPIErr.
+func run(ctx context.Context, call func() error, retry *retryConfig, idempotent bool) error {
+	var bo gax.Backoff
+	if retry != nil && retry.backoff != nil {
+		bo = *retry.backoff
+	}
+
+	shouldRetry := ShouldRetry
+	if retry != nil && retry.shouldRetry != nil {
+		shouldRetry = retry.shouldRetry
+	}
+
+	policy := RetryIdempotent
+	if retry != nil {
+		policy = retry.policy
+	}
+
+	var maxAttempts int
+	if retry != nil && retry.maxAttempts != nil {
+		maxAttempts = *retry.maxAttempts
+	}
+
+	for attempts := 1; ; attempts++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		retryable := policy != RetryNever && (policy == RetryAlways || idempotent) && shouldRetry(err)
+		if !retryable {
+			return toAPIErr(err)
+		}
+		if maxAttempts > 0 && attempts >= maxAttempts {
+			return toAPIErr(err)
+		}
+
+		pause := bo.Pause()
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
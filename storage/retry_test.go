@@ -0,0 +1,198 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRunRetriesIdempotentCallsUntilSuccess(t *testing.T) {
+	retry := &retryConfig{backoff: &gax.Backoff{Initial: 0, Max: 0, Multiplier: 1}}
+	attempts := 0
+	err := run(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return status.Error(codes.Unavailable, "try again")
+		}
+		return nil
+	}, retry, true)
+	if err != nil {
+		t.Fatalf("run() = %v, want nil", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestRunDoesNotRetryNonIdempotentCalls(t *testing.T) {
+	attempts := 0
+	wantErr := status.Error(codes.Unavailable, "try again")
+	err := run(context.Background(), func() error {
+		attempts++
+		return wantErr
+	}, nil, false)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("run() = %v, want %v", err, wantErr)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRunRespectsRetryNeverPolicy(t *testing.T) {
+	retry := &retryConfig{policy: RetryNever, backoff: &gax.Backoff{Initial: 0, Max: 0, Multiplier: 1}}
+	attempts := 0
+	wantErr := status.Error(codes.Unavailable, "try again")
+	err := run(context.Background(), func() error {
+		attempts++
+		return wantErr
+	}, retry, true)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("run() = %v, want %v", err, wantErr)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRunRetryAlwaysRetriesNonIdempotentCalls(t *testing.T) {
+	retry := &retryConfig{policy: RetryAlways, backoff: &gax.Backoff{Initial: 0, Max: 0, Multiplier: 1}}
+	attempts := 0
+	err := run(context.Background(), func() error {
+		attempts++
+		if attempts < 2 {
+			return status.Error(codes.Unavailable, "try again")
+		}
+		return nil
+	}, retry, false)
+	if err != nil {
+		t.Fatalf("run() = %v, want nil", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestRunRespectsMaxAttempts(t *testing.T) {
+	max := 2
+	retry := &retryConfig{maxAttempts: &max, backoff: &gax.Backoff{Initial: 0, Max: 0, Multiplier: 1}}
+	attempts := 0
+	wantErr := status.Error(codes.Unavailable, "try again")
+	err := run(context.Background(), func() error {
+		attempts++
+		return wantErr
+	}, retry, true)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("run() = %v, want %v", err, wantErr)
+	}
+	if attempts != max {
+		t.Errorf("attempts = %d, want %d", attempts, max)
+	}
+}
+
+func TestRunUsesCustomErrorFunc(t *testing.T) {
+	retry := &retryConfig{
+		backoff:     &gax.Backoff{Initial: 0, Max: 0, Multiplier: 1},
+		shouldRetry: func(err error) bool { return false },
+	}
+	attempts := 0
+	wantErr := status.Error(codes.Unavailable, "try again")
+	err := run(context.Background(), func() error {
+		attempts++
+		return wantErr
+	}, retry, true)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("run() = %v, want %v", err, wantErr)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1 since shouldRetry always returns false", attempts)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"grpc unavailable", status.Error(codes.Unavailable, ""), true},
+		{"grpc aborted", status.Error(codes.Aborted, ""), true},
+		{"grpc not found", status.Error(codes.NotFound, ""), false},
+		{"context deadline exceeded", context.DeadlineExceeded, true},
+	}
+	for _, tc := range cases {
+		if got := ShouldRetry(tc.err); got != tc.want {
+			t.Errorf("ShouldRetry(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIdempotentConds(t *testing.T) {
+	cases := []struct {
+		name  string
+		conds *Conditions
+		want  bool
+	}{
+		{"nil conds", nil, false},
+		{"no preconditions", &Conditions{}, false},
+		{"generation match", &Conditions{GenerationMatch: 42}, true},
+		{"metageneration match", &Conditions{MetagenerationMatch: 7}, true},
+		{"generation not match", &Conditions{GenerationNotMatch: 42}, false},
+	}
+	for _, tc := range cases {
+		if got := idempotentConds(tc.conds); got != tc.want {
+			t.Errorf("idempotentConds(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRunWrapsTerminalErrorInAPIError(t *testing.T) {
+	wantErr := status.Error(codes.NotFound, "no such object")
+	err := run(context.Background(), func() error {
+		return wantErr
+	}, nil, false)
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("run() = %v (%T), want an *apierror.APIError", err, err)
+	}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("run() = %v, want it to wrap %v", err, wantErr)
+	}
+}
+
+func TestIdempotentBucketConds(t *testing.T) {
+	cases := []struct {
+		name  string
+		conds *BucketConditions
+		want  bool
+	}{
+		{"nil conds", nil, false},
+		{"no preconditions", &BucketConditions{}, false},
+		{"metageneration match", &BucketConditions{MetagenerationMatch: 7}, true},
+	}
+	for _, tc := range cases {
+		if got := idempotentBucketConds(tc.conds); got != tc.want {
+			t.Errorf("idempotentBucketConds(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
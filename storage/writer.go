@@ -0,0 +1,144 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// A Writer writes a Cloud Storage object out to the network, and then closes
+// the object when Close is called.
+type Writer struct {
+	// ObjectAttrs are optional attributes to set on the object. Any attrs
+	// must be initialized before the first Write call. Nil or zero-valued
+	// attrs are ignored.
+	ObjectAttrs
+
+	// ChunkSize is the chunk size of the resumable upload used by the
+	// JSON/HTTP transport: the maximum number of bytes sent to the server in
+	// a single request. A ChunkSize of zero uploads the object in a single
+	// request. The gRPC transport does not use ChunkSize; it sends data in
+	// messages of at most maxPerMessageWriteSize bytes.
+	ChunkSize int
+
+	// ProgressFunc, if not nil, is invoked periodically as data is sent,
+	// with the number of bytes sent so far.
+	ProgressFunc func(int64)
+
+	ctx context.Context
+	o   *ObjectHandle
+
+	donec chan struct{}
+	obj   *ObjectAttrs
+	err   error
+
+	pw *io.PipeWriter
+	pr *io.PipeReader
+
+	opened bool
+
+	mu sync.Mutex
+	// gw is set once the upload has been opened against a transport that
+	// supports mid-stream flushes (currently only gRPC, via BidiWriteObject).
+	// It is nil for uploads opened over the JSON/HTTP transport.
+	gw *gRPCWriter
+}
+
+func (w *Writer) open() error {
+	if w.ChunkSize < 0 {
+		return errors.New("storage: Writer.ChunkSize must be non-negative")
+	}
+
+	pr, pw := io.Pipe()
+	w.pw = pw
+	w.pr = pr
+	w.donec = make(chan struct{})
+	w.opened = true
+
+	go func() {
+		err := w.o.c.tc.OpenWriter(w.ctx, w)
+		if err != nil {
+			pr.CloseWithError(err)
+		}
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+		close(w.donec)
+	}()
+	return nil
+}
+
+// Write appends to w. It implements the io.Writer interface.
+//
+// Since writes happen asynchronously, Write may return a nil error even
+// though the write failed (or will fail). Always use the error returned from
+// Writer.Close to determine whether the upload succeeded.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if !w.opened {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	return w.pw.Write(p)
+}
+
+// Close completes the write operation and flushes any buffered data. If
+// Close doesn't return an error, metadata about the written object can be
+// retrieved by calling Attrs.
+func (w *Writer) Close() error {
+	if !w.opened {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	<-w.donec
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Attrs returns metadata about a successfully written object. It's only
+// valid to call it after Close returns nil.
+func (w *Writer) Attrs() *ObjectAttrs {
+	return w.obj
+}
+
+// Flush sends any data buffered so far to the server and blocks until the
+// server has durably persisted it, returning the offset it has acknowledged.
+// Unlike Close, Flush does not finalize the object or end the upload;
+// additional calls to Write remain valid afterwards.
+//
+// Flush gives streaming uploads (logs, telemetry, and other long-lived
+// writes) fsync-like durability checkpoints without paying the cost of
+// starting a new object for every checkpoint.
+//
+// Flush is only supported when the Writer is backed by the gRPC transport
+// (see NewGRPCClient); it returns an error otherwise, since the JSON/HTTP
+// transport has no equivalent mid-stream durability signal.
+func (w *Writer) Flush() (int64, error) {
+	w.mu.Lock()
+	gw := w.gw
+	w.mu.Unlock()
+	if gw == nil {
+		return 0, errors.New("storage: Flush requires a Writer opened with the gRPC transport")
+	}
+	return gw.flush()
+}